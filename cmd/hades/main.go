@@ -0,0 +1,184 @@
+// Command hades applies a filter to JSON read from files or stdin and writes the results to stdout, like jq, using
+// the byte-level scanner of the jq package that hades links with.
+//
+// Usage:
+//
+//	hades [-c] [-r] [-null-on-missing] filter [file ...]
+//	hades -watch rules.json [-interval 1s]
+//
+// The filter uses the selector syntax of jq.Parse, e.g. ".data[].links.self". Every input may hold any number of
+// whitespace separated JSON values, each of which is filtered in turn; stdin is read when no file is given. Results
+// are pretty printed with two spaces of indentation unless -c is given. The exit status is 1 when any input or value
+// could not be filtered, and 2 when the command line is invalid.
+//
+// With -watch, hades runs until interrupted, re-applying a set of rules to their source files whenever they change,
+// as described by package watch. The rules file holds a JSON array of rules, e.g.
+//
+//	[{"source": "config.json", "filter": ".db", "output": "db.json"},
+//	 {"source": "config.json", "filter": ".cache", "callback": "http://localhost:8080/reload"}]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gabesullice/hades/lib/watch"
+	"github.com/gabesullice/jq"
+	"github.com/gabesullice/jq/scanner"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// options holds the flags that control how results are written
+type options struct {
+	compact, raw, nullOnMissing bool
+}
+
+// run executes the command with the arguments provided, which exclude the program name, and returns its exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "hades: ", 0)
+
+	var opts options
+	flags := flag.NewFlagSet("hades", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&opts.compact, "c", false, "write each result on a single line")
+	flags.BoolVar(&opts.raw, "r", false, "write string results as raw text rather than as JSON strings")
+	flags.BoolVar(&opts.nullOnMissing, "null-on-missing", false, "yield null rather than failing when a key is missing")
+	watchRules := flags.String("watch", "", "re-apply the rules in this `file` whenever their sources change")
+	interval := flags.Duration("interval", watch.DefaultInterval, "how often to poll the sources with -watch")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hades [flags] filter [file ...]")
+		fmt.Fprintln(stderr, "       hades -watch rules.json [-interval 1s]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if *watchRules != "" {
+		if err := watchFiles(*watchRules, *interval, logger); err != nil {
+			logger.Println(err)
+			return 1
+		}
+		return 0
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	filter, err := jq.Parse(flags.Arg(0))
+	if err != nil {
+		logger.Println(err)
+		return 2
+	}
+	op := jq.Chain(opts.missing(filter), jq.OpFunc(opts.format))
+
+	w := bufio.NewWriter(stdout)
+	failed := false
+	filterAll := func(name string, r io.Reader) {
+		stream := jq.StreamOptions{
+			Separator: jq.Concatenated,
+			OnError: func(index int, err error) error {
+				logger.Printf("%s: value %d; %v", name, index, err)
+				failed = true
+				return nil
+			},
+		}
+		if err := stream.Stream(r, op, w); err != nil {
+			logger.Printf("%s: %v", name, err)
+			failed = true
+		}
+	}
+
+	if flags.NArg() == 1 {
+		filterAll("stdin", stdin)
+	}
+	for _, name := range flags.Args()[1:] {
+		f, err := os.Open(name)
+		if err != nil {
+			logger.Println(err)
+			failed = true
+			continue
+		}
+		filterAll(name, bufio.NewReader(f))
+		f.Close()
+	}
+
+	if err := w.Flush(); err != nil {
+		logger.Println(err)
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// watchFiles applies the rules in the file provided whenever their sources change, until interrupted
+func watchFiles(name string, interval time.Duration, logger *log.Logger) error {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var rules []watch.Rule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	w, err := watch.New(rules)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	w.Interval = interval
+	w.Logf = logger.Printf
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	w.Run(ctx)
+	return nil
+}
+
+// missing wraps op so that it yields null for a missing key or an index out of bounds when -null-on-missing is set
+func (o options) missing(op jq.Op) jq.Op {
+	if !o.nullOnMissing {
+		return op
+	}
+	return jq.OpFunc(func(in []byte) ([]byte, error) {
+		out, err := op.Apply(in)
+		if errors.Is(err, scanner.ErrKeyNotFound) || errors.Is(err, scanner.ErrIndexOutOfBounds) {
+			return []byte("null"), nil
+		}
+		return out, err
+	})
+}
+
+// format renders a result according to the -c and -r flags
+func (o options) format(in []byte) ([]byte, error) {
+	if o.raw && bytes.HasPrefix(bytes.TrimSpace(in), []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(in, &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	}
+
+	if o.compact {
+		return jq.Compact().Apply(in)
+	}
+	return jq.Pretty("  ").Apply(in)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "in.json")
+	if err := os.WriteFile(file, []byte(`{"a":[1,2]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		Args     []string
+		Stdin    string
+		Expected string
+		Status   int
+		Logged   string
+	}{
+		"pretty": {
+			Args:     []string{".a"},
+			Stdin:    `{"a":{"b":1}}`,
+			Expected: "{\n  \"b\": 1\n}\n",
+		},
+		"compact": {
+			Args:     []string{"-c", ".a"},
+			Stdin:    `{"a": {"b": [1, 2]}}`,
+			Expected: "{\"b\":[1,2]}\n",
+		},
+		"raw": {
+			Args:     []string{"-r", ".a"},
+			Stdin:    `{"a":"a\tb"} {"a":1}`,
+			Expected: "a\tb\n1\n",
+		},
+		"json strings": {
+			Args:     []string{".a"},
+			Stdin:    `{"a":"x"}`,
+			Expected: "\"x\"\n",
+		},
+		"concatenated values": {
+			Args:     []string{"-c", ".a"},
+			Stdin:    `{"a":1} {"a":2}` + "\n" + `{"a":3}`,
+			Expected: "1\n2\n3\n",
+		},
+		"file": {
+			Args:     []string{"-c", ".a", file},
+			Stdin:    `{"a":"ignored"}`,
+			Expected: "[1,2]\n",
+		},
+		"missing key": {
+			Args:     []string{".b"},
+			Stdin:    `{"a":1} {"b":2}`,
+			Expected: "2\n",
+			Status:   1,
+			Logged:   "hades: stdin: value 0;",
+		},
+		"null on missing key": {
+			Args:     []string{"-null-on-missing", ".b"},
+			Stdin:    `{"a":1}`,
+			Expected: "null\n",
+		},
+		"null on missing index": {
+			Args:     []string{"-null-on-missing", ".[5]"},
+			Stdin:    `[1]`,
+			Expected: "null\n",
+		},
+		"missing file": {
+			Args:     []string{".a", filepath.Join(dir, "none.json"), file},
+			Expected: "[\n  1,\n  2\n]\n",
+			Status:   1,
+			Logged:   "none.json",
+		},
+		"no filter": {
+			Args:   []string{"-c"},
+			Status: 2,
+			Logged: "usage: hades",
+		},
+		"invalid filter": {
+			Args:   []string{".a[x]"},
+			Status: 2,
+			Logged: "invalid array selector",
+		},
+		"unknown flag": {
+			Args:   []string{"-x", ".a"},
+			Status: 2,
+			Logged: "flag provided but not defined",
+		},
+		"help": {
+			Args:   []string{"-h"},
+			Logged: "-null-on-missing",
+		},
+		"missing rules": {
+			Args:   []string{"-watch", filepath.Join(dir, "none.json")},
+			Status: 1,
+			Logged: "none.json",
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := run(tc.Args, strings.NewReader(tc.Stdin), &stdout, &stderr)
+			if status != tc.Status || stdout.String() != tc.Expected || !strings.Contains(stderr.String(), tc.Logged) {
+				t.Logf("status: %d; stdout: %q; stderr: %q", status, stdout.String(), stderr.String())
+				t.FailNow()
+			}
+			if tc.Status == 0 && tc.Logged == "" && stderr.Len() > 0 {
+				t.Logf("stderr: %q", stderr.String())
+				t.FailNow()
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package hadeshttp provides net/http middleware that applies jq Ops to JSON request and response bodies, e.g. to
+// filter the fields of API responses in a gateway.
+package hadeshttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gabesullice/jq"
+)
+
+// Transform returns middleware that applies op to the JSON response bodies of the handler it wraps, i.e. those with
+// a Content-Type of application/json or a +json type such as application/vnd.api+json. A JSON body is buffered in
+// full and the result of op is sent with its Content-Length; every other response is streamed through untouched, as
+// are responses to HEAD requests and responses without a body, such as 204 and 304, or with only part of one (206).
+//
+// A gzip encoded body is decoded before op is applied and the result encoded again; a body with any other
+// Content-Encoding is passed through untouched, since op cannot be applied to it. The ETag of a transformed
+// response is removed, since it no longer identifies the body. An op producing no value yields an empty body. When
+// op fails, the response is replaced by a 500 Internal Server Error and the error is logged.
+func Transform(op jq.Op) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tw := &transformWriter{w: w, status: http.StatusOK}
+			next.ServeHTTP(tw, r)
+			if !tw.buffering {
+				if !tw.wroteHeader {
+					// the handler wrote nothing at all
+					w.WriteHeader(tw.status)
+				}
+				return
+			}
+
+			if tw.body.Len() == 0 {
+				w.WriteHeader(tw.status)
+				return
+			}
+			body, err := transformBody(op, tw.body.Bytes(), w.Header().Get("Content-Encoding"))
+			if err != nil {
+				log.Printf("Transform Error: %s", err)
+				w.Header().Del("Content-Encoding")
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Del("ETag")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(tw.status)
+			w.Write(body)
+		})
+	}
+}
+
+// TransformRequest returns middleware that applies op to JSON request bodies before passing the request on to the
+// handler it wraps, with the same rules for which bodies are transformed as Transform. The body is buffered in full
+// and replaced by the result of op, uncompressed, with its Content-Length. When op fails, the request is rejected with
+// a 400 Bad Request.
+func TransformRequest(op jq.Op) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || !transformable(r.Header) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			raw, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			in, err := decode(raw, r.Header.Get("Content-Encoding"))
+			var body []byte
+			if err == nil {
+				body, err = op.Apply(in)
+			}
+			if err != nil {
+				log.Printf("Transform Error: %s", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+
+			r.Header.Del("Content-Encoding")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			r.ContentLength = int64(len(body))
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// transformWriter decides when the header is written whether the response is to be transformed, buffering its body
+// if so and passing it straight through to w otherwise
+type transformWriter struct {
+	w           http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (tw *transformWriter) Header() http.Header {
+	return tw.w.Header()
+}
+
+func (tw *transformWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader, tw.status = true, status
+
+	hasBody := status != http.StatusNoContent && status != http.StatusNotModified && status != http.StatusPartialContent
+	tw.buffering = hasBody && transformable(tw.w.Header())
+	if !tw.buffering {
+		tw.w.WriteHeader(status)
+	}
+}
+
+func (tw *transformWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.buffering {
+		return tw.body.Write(b)
+	}
+	return tw.w.Write(b)
+}
+
+// Flush implements http.Flusher for responses streamed through untouched
+func (tw *transformWriter) Flush() {
+	if tw.buffering {
+		return
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// transformBody applies op to a body with the content encoding provided and returns the result in the same encoding
+func transformBody(op jq.Op, body []byte, encoding string) ([]byte, error) {
+	in, err := decode(body, encoding)
+	if err != nil {
+		return nil, err
+	}
+	out, err := op.Apply(in)
+	if err != nil {
+		return nil, err
+	}
+	return encode(out, encoding)
+}
+
+// transformable reports whether the body described by the header provided is JSON in an encoding that can be decoded
+func transformable(h http.Header) bool {
+	return isJSON(h.Get("Content-Type")) && canDecode(h.Get("Content-Encoding"))
+}
+
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return t == "application/json" || strings.HasPrefix(t, "application/") && strings.HasSuffix(t, "+json")
+}
+
+func canDecode(encoding string) bool {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity", "gzip", "x-gzip":
+		return true
+	}
+	return false
+}
+
+func decode(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	}
+	return body, nil
+}
+
+func encode(body []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return body, nil
+}
@@ -0,0 +1,235 @@
+package hadeshttp_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gabesullice/hades/lib/hadeshttp"
+	"github.com/gabesullice/jq"
+)
+
+func TestTransform(t *testing.T) {
+	testCases := map[string]struct {
+		Method      string
+		ContentType string
+		Status      int
+		Body        string
+		Op          jq.Op
+		Expected    string
+		Code        int
+	}{
+		"json": {
+			ContentType: "application/json",
+			Body:        `{"a":1,"b":2}`,
+			Op:          jq.Dot("b"),
+			Expected:    `2`,
+			Code:        200,
+		},
+		"json api": {
+			ContentType: "application/vnd.api+json",
+			Body:        `{"data":[]}`,
+			Op:          jq.Dot("data"),
+			Expected:    `[]`,
+			Code:        200,
+		},
+		"charset": {
+			ContentType: "application/json; charset=utf-8",
+			Body:        `[1,2]`,
+			Op:          jq.Index(0),
+			Expected:    `1`,
+			Code:        200,
+		},
+		"status": {
+			ContentType: "application/json",
+			Status:      404,
+			Body:        `{"e":"x"}`,
+			Op:          jq.Dot("e"),
+			Expected:    `"x"`,
+			Code:        404,
+		},
+		"not json": {
+			ContentType: "text/plain",
+			Body:        `{"a":1}`,
+			Op:          jq.Dot("a"),
+			Expected:    `{"a":1}`,
+			Code:        200,
+		},
+		"head": {
+			Method:      "HEAD",
+			ContentType: "application/json",
+			Op:          jq.Dot("a"),
+			Code:        200,
+		},
+		"no content": {
+			ContentType: "application/json",
+			Status:      204,
+			Op:          jq.Dot("a"),
+			Code:        204,
+		},
+		"empty": {
+			ContentType: "application/json",
+			Op:          jq.Dot("a"),
+			Code:        200,
+		},
+		"failed": {
+			ContentType: "application/json",
+			Body:        `{"a":1}`,
+			Op:          jq.Dot("b"),
+			Expected:    "Internal Server Error\n",
+			Code:        500,
+		},
+		"no value": {
+			ContentType: "application/json",
+			Body:        `{"a":1}`,
+			Op:          jq.OpFunc(func([]byte) ([]byte, error) { return nil, nil }),
+			Code:        200,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			backend := func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.ContentType)
+				w.Header().Set("ETag", `"v1"`)
+				if tc.Status != 0 {
+					w.WriteHeader(tc.Status)
+				}
+				io.WriteString(w, tc.Body)
+			}
+			handler := hadeshttp.Transform(tc.Op)(http.HandlerFunc(backend))
+
+			method := tc.Method
+			if method == "" {
+				method = "GET"
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+			if rec.Code != tc.Code || rec.Body.String() != tc.Expected {
+				t.Logf("code: %d, body: %q", rec.Code, rec.Body.String())
+				t.FailNow()
+			}
+			if transformed := rec.Body.String() != tc.Body; transformed && tc.Code == 200 {
+				length := strconv.Itoa(len(tc.Expected))
+				if rec.Header().Get("ETag") != "" || rec.Header().Get("Content-Length") != length {
+					t.Logf("header: %v", rec.Header())
+					t.FailNow()
+				}
+			}
+		})
+	}
+}
+
+func TestTransformGzip(t *testing.T) {
+	handler := hadeshttp.Transform(jq.Dot("a"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, `{"a":{"b":true}}`)
+		zw.Close()
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.FailNow()
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != `{"b":true}` || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Logf("body: %q, err: %v", body, err)
+		t.FailNow()
+	}
+}
+
+func TestTransformUnknownEncoding(t *testing.T) {
+	handler := hadeshttp.Transform(jq.Dot("a"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, "compressed")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "compressed" {
+		t.Logf("body: %q", rec.Body.String())
+		t.FailNow()
+	}
+}
+
+func TestTransformRequest(t *testing.T) {
+	testCases := map[string]struct {
+		ContentType string
+		Encoding    string
+		Body        []byte
+		Op          jq.Op
+		Expected    string
+		Code        int
+	}{
+		"json": {
+			ContentType: "application/json",
+			Body:        []byte(`{"a":{"b":1}}`),
+			Op:          jq.Dot("a"),
+			Expected:    `{"b":1}`,
+			Code:        200,
+		},
+		"gzip": {
+			ContentType: "application/json",
+			Encoding:    "gzip",
+			Body:        gzipped(`{"a":2}`),
+			Op:          jq.Dot("a"),
+			Expected:    `2`,
+			Code:        200,
+		},
+		"not json": {
+			ContentType: "text/plain",
+			Body:        []byte(`{"a":1}`),
+			Op:          jq.Dot("a"),
+			Expected:    `{"a":1}`,
+			Code:        200,
+		},
+		"failed": {
+			ContentType: "application/json",
+			Body:        []byte(`{"a":1}`),
+			Op:          jq.Dot("b"),
+			Code:        400,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			var received string
+			handler := hadeshttp.TransformRequest(tc.Op)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if r.ContentLength != int64(len(body)) || r.Header.Get("Content-Encoding") != "" {
+					t.Errorf("content length: %d, encoding: %q", r.ContentLength, r.Header.Get("Content-Encoding"))
+				}
+				received = string(body)
+			}))
+
+			r := httptest.NewRequest("POST", "/", bytes.NewReader(tc.Body))
+			r.Header.Set("Content-Type", tc.ContentType)
+			if tc.Encoding != "" {
+				r.Header.Set("Content-Encoding", tc.Encoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tc.Code || received != tc.Expected {
+				t.Logf("code: %d, received: %q", rec.Code, received)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, s)
+	zw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,208 @@
+// Package watch re-applies jq filters to JSON files whenever they change, writing the results to files or posting
+// them to HTTP callbacks, e.g. to derive service-specific configuration fragments from a central JSON document.
+//
+// Files are polled rather than watched with OS notifications: a source counts as changed when its modification time
+// or size differs from when it was last read.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gabesullice/jq"
+)
+
+// DefaultInterval is how often a Watcher polls its sources unless told otherwise
+const DefaultInterval = time.Second
+
+// Rule derives an output from a JSON source file by applying a filter, with the selector syntax of jq.Parse, to it.
+// The result is written to the Output file, posted to the Callback URL, or both.
+type Rule struct {
+	Source   string `json:"source"`
+	Filter   string `json:"filter"`
+	Output   string `json:"output,omitempty"`
+	Callback string `json:"callback,omitempty"`
+}
+
+// Watcher polls the sources of a set of rules and applies the rules whose sources have changed
+type Watcher struct {
+	// Interval is how often the sources are polled; DefaultInterval is used when it is not positive
+	Interval time.Duration
+	// Client posts the results to callbacks; http.DefaultClient is used when it is nil
+	Client *http.Client
+	// Logf reports failures to apply a rule, which do not stop the Watcher; log.Printf is used when it is nil
+	Logf func(format string, args ...interface{})
+
+	rules    []Rule
+	programs []*jq.Program
+	// seen holds the state of every source when its rules were last applied successfully
+	seen map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// New returns a Watcher for the rules provided. It is an error for a rule to have no source, a filter that cannot be
+// parsed, or neither an output nor a callback.
+func New(rules []Rule) (*Watcher, error) {
+	w := &Watcher{rules: rules, seen: make(map[string]fileState)}
+	for i, rule := range rules {
+		if rule.Source == "" {
+			return nil, fmt.Errorf("rule %d; no source", i)
+		}
+		if rule.Output == "" && rule.Callback == "" {
+			return nil, fmt.Errorf("rule %d; no output or callback", i)
+		}
+		p, err := jq.Compile(rule.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d; %w", i, err)
+		}
+		w.programs = append(w.programs, p)
+	}
+	return w, nil
+}
+
+// Run applies every rule once and then polls the sources until ctx is done, applying the rules of the sources that
+// change; it returns ctx.Err(). A rule that fails is reported to Logf and retried at the next poll.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks every source once, applying the rules of those that have changed since their rules were last applied
+// successfully, or that have never been applied, and returns the number of rules applied. Failures are reported to
+// Logf, and the source's rules are applied again at the next poll.
+func (w *Watcher) Poll(ctx context.Context) int {
+	applied := 0
+	states := make(map[string]fileState)
+	failed := make(map[string]bool)
+	for i, rule := range w.rules {
+		state, ok := states[rule.Source]
+		if !ok {
+			info, err := os.Stat(rule.Source)
+			if err != nil {
+				w.logf("%s: %v", rule.Source, err)
+				failed[rule.Source] = true
+				continue
+			}
+			state = fileState{modTime: info.ModTime(), size: info.Size()}
+			states[rule.Source] = state
+		}
+		if seen, ok := w.seen[rule.Source]; ok && seen == state {
+			continue
+		}
+
+		if err := w.apply(ctx, rule, w.programs[i]); err != nil {
+			w.logf("%s: rule %d; %v", rule.Source, i, err)
+			failed[rule.Source] = true
+			continue
+		}
+		applied++
+	}
+
+	for source, state := range states {
+		if !failed[source] {
+			w.seen[source] = state
+		}
+	}
+	return applied
+}
+
+// apply applies a rule to the current content of its source
+func (w *Watcher) apply(ctx context.Context, rule Rule, p *jq.Program) error {
+	in, err := os.ReadFile(rule.Source)
+	if err != nil {
+		return err
+	}
+	out, err := p.Run(in)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return errors.New("filter produced no value")
+	}
+	out = append(out, '\n')
+
+	if rule.Output != "" {
+		if err := writeFile(rule.Output, out); err != nil {
+			return err
+		}
+	}
+	if rule.Callback != "" {
+		if err := w.post(ctx, rule.Callback, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile replaces the file with the content provided by renaming a temporary file over it, so that readers never
+// see a partially written file
+func writeFile(name string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (w *Watcher) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback %s responded %s", url, resp.Status)
+	}
+	return nil
+}
+
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.Logf != nil {
+		w.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
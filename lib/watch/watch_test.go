@@ -0,0 +1,158 @@
+package watch_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabesullice/hades/lib/watch"
+)
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		Rule     watch.Rule
+		HasError bool
+	}{
+		"output":    {Rule: watch.Rule{Source: "a.json", Filter: ".a", Output: "b.json"}},
+		"callback":  {Rule: watch.Rule{Source: "a.json", Filter: ".a", Callback: "http://localhost/"}},
+		"no source": {Rule: watch.Rule{Filter: ".a", Output: "b.json"}, HasError: true},
+		"no output": {Rule: watch.Rule{Source: "a.json", Filter: ".a"}, HasError: true},
+		"invalid":   {Rule: watch.Rule{Source: "a.json", Filter: ".a[", Output: "b.json"}, HasError: true},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			_, err := watch.New([]watch.Rule{tc.Rule})
+			if (err != nil) != tc.HasError {
+				t.Logf("error: %v", err)
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "config.json")
+	output := filepath.Join(dir, "db.json")
+
+	var mu sync.Mutex
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		posted = append(posted, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var logged []string
+	w, err := watch.New([]watch.Rule{
+		{Source: source, Filter: ".db", Output: output},
+		{Source: source, Filter: ".cache.ttl", Callback: server.URL},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	w.Logf = func(format string, args ...interface{}) { logged = append(logged, format) }
+
+	// a missing source is reported and retried
+	if applied := w.Poll(context.Background()); applied != 0 || len(logged) != 2 {
+		t.Logf("applied: %d, logged: %v", applied, logged)
+		t.FailNow()
+	}
+
+	write(t, source, `{"db":{"host":"a"},"cache":{"ttl":60}}`)
+	if applied := w.Poll(context.Background()); applied != 2 {
+		t.Logf("applied: %d, logged: %v", applied, logged)
+		t.FailNow()
+	}
+	expect(t, output, "{\"host\":\"a\"}\n")
+
+	// nothing changed
+	if applied := w.Poll(context.Background()); applied != 0 {
+		t.Logf("applied: %d", applied)
+		t.FailNow()
+	}
+
+	write(t, source, `{"db":{"host":"bb"},"cache":{"ttl":120}}`)
+	if applied := w.Poll(context.Background()); applied != 2 {
+		t.Logf("applied: %d, logged: %v", applied, logged)
+		t.FailNow()
+	}
+	expect(t, output, "{\"host\":\"bb\"}\n")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 2 || posted[0] != "60\n" || posted[1] != "120\n" {
+		t.Logf("posted: %q", posted)
+		t.FailNow()
+	}
+}
+
+func TestPollFailedRule(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "config.json")
+	output := filepath.Join(dir, "out.json")
+	write(t, source, `{"a":1}`)
+
+	w, err := watch.New([]watch.Rule{{Source: source, Filter: ".b", Output: output}})
+	if err != nil {
+		t.FailNow()
+	}
+	w.Logf = func(string, ...interface{}) {}
+	if applied := w.Poll(context.Background()); applied != 0 {
+		t.FailNow()
+	}
+	// the unchanged source is tried again, since its rule failed
+	os.WriteFile(source, []byte(`{"b":2}`), 0644)
+	if applied := w.Poll(context.Background()); applied != 1 {
+		t.FailNow()
+	}
+	expect(t, output, "2\n")
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "config.json")
+	output := filepath.Join(dir, "out.json")
+	write(t, source, `{"a":1}`)
+
+	w, err := watch.New([]watch.Rule{{Source: source, Filter: ".a", Output: output}})
+	if err != nil {
+		t.FailNow()
+	}
+	w.Interval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Run(ctx); err != context.DeadlineExceeded {
+		t.FailNow()
+	}
+	expect(t, output, "1\n")
+}
+
+// write replaces the content of the file and moves its modification time on, so that the change is seen even when
+// the file system's timestamps are coarse
+func write(t *testing.T, name, content string) {
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.FailNow()
+	}
+	later := time.Now().Add(time.Duration(len(content)) * time.Second)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.FailNow()
+	}
+}
+
+func expect(t *testing.T, name, content string) {
+	b, err := os.ReadFile(name)
+	if err != nil || string(b) != content {
+		t.Logf("%s: %q", name, b)
+		t.FailNow()
+	}
+}